@@ -0,0 +1,380 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bigquery "code.google.com/p/google-api-go-client/bigquery/v2"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// maxRowsPerRequest is the largest number of rows batched into a single
+// tabledata.insertAll call, regardless of BatchOptions.MaxBatchRows.
+const maxRowsPerRequest = 500
+
+// BatchOptions configures a BatchedClient.
+type BatchOptions struct {
+	// MaxBatchRows caps the number of rows assembled into a single
+	// insertAll request. Values above maxRowsPerRequest are clamped.
+	MaxBatchRows int
+
+	// MaxBatchBytes caps the approximate JSON-encoded size of a batch. A
+	// batch is flushed as soon as either the row or byte limit is hit.
+	MaxBatchBytes int
+
+	// FlushInterval is the longest a row will wait in the queue before
+	// being sent, even if the batch isn't full.
+	FlushInterval time.Duration
+
+	// MaxInFlight bounds the number of insertAll requests outstanding at
+	// once, so a slow BigQuery doesn't let unbounded goroutines pile up.
+	MaxInFlight int
+
+	// QueueSize is the capacity of the row queue between InsertRow and
+	// the flushing goroutine.
+	QueueSize int
+
+	// MaxRetries is the number of retry attempts for rows that come back
+	// in a partial insert failure, before they are counted as dropped.
+	MaxRetries int
+
+	// DropOnFull controls what InsertRow does when the queue is full: if
+	// true the row is dropped and Dropped is incremented; if false,
+	// InsertRow blocks until space is available.
+	DropOnFull bool
+
+	// FlushTimeout bounds how long a single insertAll request (across all
+	// of its retries) is allowed to run before the remaining rows are
+	// dropped. Without this, a slow or unreachable BigQuery can hang a
+	// flush indefinitely.
+	FlushTimeout time.Duration
+
+	// CloseTimeout bounds how long Close waits for the flush loop to
+	// drain and any in-flight flushes to finish before giving up and
+	// returning an error, so a stuck BigQuery can't hang process
+	// shutdown.
+	CloseTimeout time.Duration
+}
+
+// DefaultBatchOptions returns reasonable defaults for high-frequency stat
+// emission: 500-row batches, flushed at least every five seconds, with a
+// handful of requests in flight at once.
+func DefaultBatchOptions() BatchOptions {
+	return BatchOptions{
+		MaxBatchRows:  maxRowsPerRequest,
+		MaxBatchBytes: 10 << 20, // 10MB, BigQuery's per-request limit.
+		FlushInterval: 5 * time.Second,
+		MaxInFlight:   4,
+		QueueSize:     10000,
+		MaxRetries:    5,
+		DropOnFull:    true,
+		FlushTimeout:  30 * time.Second,
+		CloseTimeout:  30 * time.Second,
+	}
+}
+
+// BatchMetrics reports counters for a BatchedClient's lifetime. All fields
+// are updated atomically and safe to read concurrently.
+type BatchMetrics struct {
+	Enqueued uint64
+	Flushed  uint64
+	Dropped  uint64
+	Retried  uint64
+}
+
+type queuedRow struct {
+	insertId string
+	json     map[string]bigquery.JsonValue
+}
+
+// BatchedClient buffers rows written with InsertRow and flushes them to
+// BigQuery in batches, retrying partial failures with backoff. cadvisor
+// emits stats far more often than a naive one-row-per-request client can
+// sustain against BigQuery's per-request quota.
+type BatchedClient struct {
+	client  *Client
+	service *bigquery.Service
+	opts    BatchOptions
+
+	queue    chan queuedRow
+	inFlight chan struct{}
+
+	metrics BatchMetrics
+
+	nextInsertId uint64
+
+	stop chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewBatchedClient wraps c with a buffered, asynchronous writer. The
+// returned BatchedClient owns c and will call c.Close() when it is closed.
+func NewBatchedClient(c *Client, opts BatchOptions) (*BatchedClient, error) {
+	if c == nil {
+		return nil, fmt.Errorf("NewBatchedClient: nil client")
+	}
+	if opts.MaxBatchRows <= 0 || opts.MaxBatchRows > maxRowsPerRequest {
+		opts.MaxBatchRows = maxRowsPerRequest
+	}
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = DefaultBatchOptions().MaxBatchBytes
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultBatchOptions().FlushInterval
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 1
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = opts.MaxBatchRows
+	}
+	if opts.FlushTimeout <= 0 {
+		opts.FlushTimeout = DefaultBatchOptions().FlushTimeout
+	}
+	if opts.CloseTimeout <= 0 {
+		opts.CloseTimeout = DefaultBatchOptions().CloseTimeout
+	}
+
+	if c.token == nil {
+		return nil, fmt.Errorf("NewBatchedClient: client has no token source")
+	}
+	// flushBatch uses its own http.Client, built from the wrapped
+	// Client's token source but with a hard request Timeout, so a slow
+	// or unreachable BigQuery bounds a single flush attempt instead of
+	// hanging it (and, transitively, Close) forever.
+	httpClient := &http.Client{
+		Transport: oauth2.NewClient(context.Background(), c.token).Transport,
+		Timeout:   opts.FlushTimeout,
+	}
+	service, err := bigquery.New(httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("NewBatchedClient: failed to create bigquery service: %v", err)
+	}
+
+	b := &BatchedClient{
+		client:   c,
+		service:  service,
+		opts:     opts,
+		queue:    make(chan queuedRow, opts.QueueSize),
+		inFlight: make(chan struct{}, opts.MaxInFlight),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b, nil
+}
+
+// InsertRow enqueues rowData to be written on a future flush. Depending on
+// opts.DropOnFull, a full queue either drops the row (incrementing
+// Dropped) or blocks until space frees up.
+func (b *BatchedClient) InsertRow(rowData map[string]interface{}) error {
+	jsonRow := make(map[string]bigquery.JsonValue, len(rowData))
+	for key, value := range rowData {
+		jsonRow[key] = bigquery.JsonValue(value)
+	}
+	row := queuedRow{
+		insertId: strconv.FormatUint(atomic.AddUint64(&b.nextInsertId, 1), 10),
+		json:     jsonRow,
+	}
+
+	if b.opts.DropOnFull {
+		select {
+		case b.queue <- row:
+			atomic.AddUint64(&b.metrics.Enqueued, 1)
+			return nil
+		default:
+			atomic.AddUint64(&b.metrics.Dropped, 1)
+			return fmt.Errorf("InsertRow: queue full, row dropped")
+		}
+	}
+
+	select {
+	case b.queue <- row:
+		atomic.AddUint64(&b.metrics.Enqueued, 1)
+		return nil
+	case <-b.stop:
+		return fmt.Errorf("InsertRow: client closed")
+	}
+}
+
+// Metrics returns a snapshot of the batch counters.
+func (b *BatchedClient) Metrics() BatchMetrics {
+	return BatchMetrics{
+		Enqueued: atomic.LoadUint64(&b.metrics.Enqueued),
+		Flushed:  atomic.LoadUint64(&b.metrics.Flushed),
+		Dropped:  atomic.LoadUint64(&b.metrics.Dropped),
+		Retried:  atomic.LoadUint64(&b.metrics.Retried),
+	}
+}
+
+// Close stops the background flusher, flushing any pending rows first, and
+// closes the underlying Client. It will not block longer than
+// opts.CloseTimeout waiting for the flush loop to drain and any in-flight
+// flushes to finish; if that deadline passes, Close returns an error
+// without closing the underlying Client, and those rows are left
+// un-flushed (counted in Metrics().Dropped once their own FlushTimeout
+// elapses).
+func (b *BatchedClient) Close() error {
+	close(b.stop)
+	deadline := time.After(b.opts.CloseTimeout)
+
+	select {
+	case <-b.done:
+	case <-deadline:
+		return fmt.Errorf("BatchedClient: Close timed out after %v waiting for the flush loop to drain", b.opts.CloseTimeout)
+	}
+
+	waitDone := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(waitDone)
+	}()
+	select {
+	case <-waitDone:
+	case <-deadline:
+		return fmt.Errorf("BatchedClient: Close timed out after %v waiting for in-flight flushes", b.opts.CloseTimeout)
+	}
+
+	return b.client.Close()
+}
+
+// run is the background goroutine that assembles and flushes batches. It
+// exits once stop is closed and the queue has been drained.
+func (b *BatchedClient) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]queuedRow, 0, b.opts.MaxBatchRows)
+	approxBytes := 0
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		rows := batch
+		batch = make([]queuedRow, 0, b.opts.MaxBatchRows)
+		approxBytes = 0
+
+		b.inFlight <- struct{}{}
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			defer func() { <-b.inFlight }()
+			b.flushBatch(rows)
+		}()
+	}
+
+	for {
+		select {
+		case row, ok := <-b.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, row)
+			approxBytes += approxRowSize(row)
+			if len(batch) >= b.opts.MaxBatchRows || approxBytes >= b.opts.MaxBatchBytes {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-b.stop:
+			// Drain whatever is already queued before shutting down.
+			for {
+				select {
+				case row := <-b.queue:
+					batch = append(batch, row)
+					approxBytes += approxRowSize(row)
+					if len(batch) >= b.opts.MaxBatchRows {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// flushBatch sends rows as a single insertAll request, retrying any rows
+// that come back in a partial failure with exponential backoff. Each
+// individual insertAll call is bounded by opts.FlushTimeout via b.service's
+// http.Client, and the whole retry loop gives up once opts.FlushTimeout has
+// elapsed overall, so a slow or unreachable BigQuery can never hang a flush
+// (or, transitively, Close) indefinitely.
+func (b *BatchedClient) flushBatch(rows []queuedRow) {
+	deadline := time.Now().Add(b.opts.FlushTimeout)
+
+	backoff := 100 * time.Millisecond
+	for attempt := 0; len(rows) > 0 && attempt <= b.opts.MaxRetries && time.Now().Before(deadline); attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+			atomic.AddUint64(&b.metrics.Retried, uint64(len(rows)))
+		}
+
+		reqRows := make([]*bigquery.TableDataInsertAllRequestRows, len(rows))
+		for i, row := range rows {
+			reqRows[i] = &bigquery.TableDataInsertAllRequestRows{
+				InsertId: row.insertId,
+				Json:     row.json,
+			}
+		}
+		insertRequest := &bigquery.TableDataInsertAllRequest{Rows: reqRows}
+
+		result, err := b.service.Tabledata.InsertAll(*projectId, b.client.datasetId, b.client.tableId, insertRequest).Do()
+		if err != nil {
+			// Request-level failure: retry the whole batch.
+			continue
+		}
+		atomic.AddUint64(&b.metrics.Flushed, uint64(len(rows)-len(result.InsertErrors)))
+		if len(result.InsertErrors) == 0 {
+			return
+		}
+
+		failed := make([]queuedRow, 0, len(result.InsertErrors))
+		for _, insertErr := range result.InsertErrors {
+			if insertErr.Index < int64(len(rows)) {
+				failed = append(failed, rows[insertErr.Index])
+			}
+		}
+		rows = failed
+	}
+
+	if len(rows) > 0 {
+		atomic.AddUint64(&b.metrics.Dropped, uint64(len(rows)))
+	}
+}
+
+// approxRowSize estimates the JSON-encoded size of a row for the purposes
+// of BatchOptions.MaxBatchBytes, without paying for a real marshal.
+func approxRowSize(row queuedRow) int {
+	size := len(row.insertId)
+	for key, value := range row.json {
+		size += len(key) + len(fmt.Sprintf("%v", value)) + 4
+	}
+	return size
+}
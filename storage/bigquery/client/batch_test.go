@@ -0,0 +1,181 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	bigquery "code.google.com/p/google-api-go-client/bigquery/v2"
+	"golang.org/x/oauth2"
+)
+
+func TestInsertRowDropsWhenQueueFullAndDropOnFull(t *testing.T) {
+	b := &BatchedClient{
+		opts:  BatchOptions{DropOnFull: true},
+		queue: make(chan queuedRow, 1),
+		stop:  make(chan struct{}),
+	}
+
+	if err := b.InsertRow(map[string]interface{}{"a": 1}); err != nil {
+		t.Fatalf("first InsertRow: %v", err)
+	}
+	if err := b.InsertRow(map[string]interface{}{"a": 2}); err == nil {
+		t.Fatalf("expected second InsertRow to be dropped once the queue is full")
+	}
+	if got := atomic.LoadUint64(&b.metrics.Dropped); got != 1 {
+		t.Errorf("expected Dropped=1, got %d", got)
+	}
+}
+
+func TestInsertRowBlocksWhenQueueFullAndNotDropOnFull(t *testing.T) {
+	b := &BatchedClient{
+		opts:  BatchOptions{DropOnFull: false},
+		queue: make(chan queuedRow, 1),
+		stop:  make(chan struct{}),
+	}
+
+	if err := b.InsertRow(map[string]interface{}{"a": 1}); err != nil {
+		t.Fatalf("first InsertRow: %v", err)
+	}
+
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- b.InsertRow(map[string]interface{}{"a": 2})
+	}()
+
+	select {
+	case err := <-blocked:
+		t.Fatalf("expected InsertRow to block while the queue is full, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(b.stop)
+
+	select {
+	case err := <-blocked:
+		if err == nil {
+			t.Fatalf("expected InsertRow to report the client as closed, got nil error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("InsertRow did not return after stop was closed")
+	}
+}
+
+// newTestBatchedClient builds a BatchedClient whose service talks to a local
+// httptest.Server instead of the real BigQuery API.
+func newTestBatchedClient(t *testing.T, opts BatchOptions, handler http.HandlerFunc) (*BatchedClient, *httptest.Server) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+
+	c := &Client{
+		token:     oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test"}),
+		datasetId: "dataset",
+		tableId:   "table",
+	}
+
+	b, err := NewBatchedClient(c, opts)
+	if err != nil {
+		server.Close()
+		t.Fatalf("NewBatchedClient: %v", err)
+	}
+	b.service.BasePath = server.URL + "/"
+	return b, server
+}
+
+func TestFlushBatchRetriesFailedRowsWithTheSameInsertId(t *testing.T) {
+	var mu sync.Mutex
+	var seenInsertIds []string
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var req bigquery.TableDataInsertAllRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+
+		mu.Lock()
+		firstCall := len(seenInsertIds) == 0
+		for _, row := range req.Rows {
+			seenInsertIds = append(seenInsertIds, row.InsertId)
+		}
+		mu.Unlock()
+
+		resp := &bigquery.TableDataInsertAllResponse{}
+		if firstCall {
+			resp.InsertErrors = []*bigquery.TableDataInsertAllResponseInsertErrors{{Index: 0}}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+
+	opts := DefaultBatchOptions()
+	opts.MaxRetries = 2
+	b, server := newTestBatchedClient(t, opts, handler)
+	defer server.Close()
+	defer b.Close()
+
+	b.flushBatch([]queuedRow{{insertId: "row-1", json: map[string]bigquery.JsonValue{"a": bigquery.JsonValue("1")}}})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seenInsertIds) != 2 || seenInsertIds[0] != "row-1" || seenInsertIds[1] != "row-1" {
+		t.Fatalf("expected row-1 to be retried with the same InsertId, got %v", seenInsertIds)
+	}
+	if got := atomic.LoadUint64(&b.metrics.Retried); got != 1 {
+		t.Errorf("expected Retried=1, got %d", got)
+	}
+	if got := atomic.LoadUint64(&b.metrics.Flushed); got != 1 {
+		t.Errorf("expected Flushed=1, got %d", got)
+	}
+}
+
+func TestCloseReturnsErrorAfterCloseTimeout(t *testing.T) {
+	blockDone := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		<-blockDone
+	}
+
+	opts := DefaultBatchOptions()
+	opts.FlushTimeout = 300 * time.Millisecond
+	opts.CloseTimeout = 50 * time.Millisecond
+	opts.MaxRetries = 0
+	opts.FlushInterval = time.Hour
+
+	b, server := newTestBatchedClient(t, opts, handler)
+	defer func() {
+		close(blockDone)
+		server.Close()
+	}()
+
+	if err := b.InsertRow(map[string]interface{}{"a": 1}); err != nil {
+		t.Fatalf("InsertRow: %v", err)
+	}
+
+	start := time.Now()
+	err := b.Close()
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("expected Close to time out while the flush is stuck, got nil error")
+	}
+	if elapsed > opts.CloseTimeout+200*time.Millisecond {
+		t.Fatalf("Close took %v, expected to return promptly after CloseTimeout %v", elapsed, opts.CloseTimeout)
+	}
+}
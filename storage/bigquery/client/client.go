@@ -18,21 +18,20 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"strings"
 
-	"code.google.com/p/goauth2/oauth"
-	"code.google.com/p/goauth2/oauth/jwt"
 	bigquery "code.google.com/p/google-api-go-client/bigquery/v2"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
 )
 
 var (
-	// TODO(jnagal): Condense all flags to an identity file and a pem key file.
-	clientId       = flag.String("bq_id", "", "Client ID")
-	clientSecret   = flag.String("bq_secret", "notasecret", "Client Secret")
-	projectId      = flag.String("bq_project_id", "", "Bigquery project ID")
-	serviceAccount = flag.String("bq_account", "", "Service account email")
-	pemFile        = flag.String("bq_credentials_file", "", "Credential Key file (pem)")
+	projectId       = flag.String("bq_project_id", "", "Bigquery project ID")
+	serviceAccount  = flag.String("bq_account", "", "Service account email (used with -bq_credentials_file)")
+	pemFile         = flag.String("bq_credentials_file", "", "Credential Key file (pem), deprecated in favor of -bq_credentials_json")
+	credentialsJson = flag.String("bq_credentials_json", "", "Credential Key file (JSON service account key)")
 )
 
 const (
@@ -42,51 +41,59 @@ const (
 
 type Client struct {
 	service   *bigquery.Service
-	token     *oauth.Token
+	token     oauth2.TokenSource
 	datasetId string
 	tableId   string
 }
 
-// Helper method to create an authenticated connection.
-func connect() (*oauth.Token, *bigquery.Service, error) {
-	if *clientId == "" {
-		return nil, nil, fmt.Errorf("No client id specified")
-	}
-	if *serviceAccount == "" {
-		return nil, nil, fmt.Errorf("No service account specified")
-	}
+// Helper method to create an authenticated connection. Credentials are
+// resolved in order: a JSON service account key (-bq_credentials_json), a
+// legacy PEM key (-bq_credentials_file, paired with -bq_account), and
+// finally Application Default Credentials, so cadvisor running on GCE/GKE
+// picks up the instance service account with no flags at all.
+func connect() (oauth2.TokenSource, *bigquery.Service, error) {
 	if *projectId == "" {
 		return nil, nil, fmt.Errorf("No project id specified")
 	}
-	authScope := bigquery.BigqueryScope
-	if *pemFile == "" {
-		return nil, nil, fmt.Errorf("No credentials specified")
-	}
-	pemBytes, err := ioutil.ReadFile(*pemFile)
-	if err != nil {
-		return nil, nil, fmt.Errorf("Could not access credential file %v - %v", pemFile, err)
-	}
 
-	t := jwt.NewToken(*serviceAccount, authScope, pemBytes)
-	token, err := t.Assert(&http.Client{})
-	if err != nil {
-		fmt.Printf("Invalid token: %v\n", err)
-		return nil, nil, err
-	}
-	config := &oauth.Config{
-		ClientId:     *clientId,
-		ClientSecret: *clientSecret,
-		Scope:        authScope,
-		AuthURL:      "https://accounts.google.com/o/oauth2/auth",
-		TokenURL:     "https://accounts.google.com/o/oauth2/token",
-	}
+	ctx := context.Background()
+	var token oauth2.TokenSource
 
-	transport := &oauth.Transport{
-		Token:  token,
-		Config: config,
+	switch {
+	case *credentialsJson != "":
+		jsonKey, err := ioutil.ReadFile(*credentialsJson)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not access credential file %v - %v", *credentialsJson, err)
+		}
+		config, err := google.JWTConfigFromJSON(jsonKey, bigquery.BigqueryScope)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid JSON service account key %v - %v", *credentialsJson, err)
+		}
+		token = config.TokenSource(ctx)
+	case *pemFile != "":
+		if *serviceAccount == "" {
+			return nil, nil, fmt.Errorf("No service account specified")
+		}
+		pemBytes, err := ioutil.ReadFile(*pemFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not access credential file %v - %v", *pemFile, err)
+		}
+		config := &jwt.Config{
+			Email:      *serviceAccount,
+			PrivateKey: pemBytes,
+			Scopes:     []string{bigquery.BigqueryScope},
+			TokenURL:   google.Endpoint.TokenURL,
+		}
+		token = config.TokenSource(ctx)
+	default:
+		creds, err := google.FindDefaultCredentials(ctx, bigquery.BigqueryScope)
+		if err != nil {
+			return nil, nil, fmt.Errorf("No credentials specified and no application default credentials found: %v", err)
+		}
+		token = creds.TokenSource
 	}
-	client := transport.Client()
 
+	client := oauth2.NewClient(ctx, token)
 	service, err := bigquery.New(client)
 	if err != nil {
 		fmt.Printf("Failed to create new service: %v\n", err)
@@ -114,23 +121,14 @@ func (c *Client) Close() error {
 	return nil
 }
 
-// Helper method to return the bigquery service connection.
-// Expired connection is refreshed.
+// Helper method to return the bigquery service connection. The token
+// source refreshes itself transparently as the underlying oauth2 http
+// Client makes requests, so unlike the old goauth2-based connect() there's
+// no need to detect expiry and reconnect here.
 func (c *Client) getService() (*bigquery.Service, error) {
 	if c.token == nil || c.service == nil {
 		return nil, fmt.Errorf("Service not initialized")
 	}
-
-	// Refresh expired token.
-	if c.token.Expired() {
-		token, service, err := connect()
-		if err != nil {
-			return nil, err
-		}
-		c.token = token
-		c.service = service
-		return service, nil
-	}
 	return c.service, nil
 }
 
@@ -167,30 +165,11 @@ func (c *Client) CreateDataset(datasetId string) error {
 	return nil
 }
 
-// Create a table with provided table ID and schema.
-// Schema is currently not updated if the table already exists.
+// Create a table with provided table ID and schema. If the table already
+// exists, its schema is extended in place with any new fields (see
+// CreateTableWithOptions for control over that behavior).
 func (c *Client) CreateTable(tableId string, schema *bigquery.TableSchema) error {
-	if c.service == nil || c.datasetId == "" {
-		return fmt.Errorf("No dataset created")
-	}
-	_, err := c.service.Tables.Get(*projectId, c.datasetId, tableId).Do()
-	if err != nil {
-		// Create a new table.
-		_, err := c.service.Tables.Insert(*projectId, c.datasetId, &bigquery.Table{
-			Schema: schema,
-			TableReference: &bigquery.TableReference{
-				DatasetId: c.datasetId,
-				ProjectId: *projectId,
-				TableId:   tableId,
-			},
-		}).Do()
-		if err != nil {
-			return err
-		}
-	}
-	// TODO(jnagal): Update schema if it has changed. We can only extend existing schema.
-	c.tableId = tableId
-	return nil
+	return c.CreateTableWithOptions(tableId, schema, CreateTableOptions{AllowAdd: true})
 }
 
 // Add a row to the connected table.
@@ -209,7 +188,8 @@ func (c *Client) InsertRow(rowData map[string]interface{}) error {
 		},
 	}
 
-	// TODO(jnagal): Batch insert requests.
+	// For high-frequency callers, see BatchedClient, which buffers and
+	// batches rows instead of sending one insertAll request per row.
 	insertRequest := &bigquery.TableDataInsertAllRequest{Rows: rows}
 
 	result, err := service.Tabledata.InsertAll(*projectId, c.datasetId, c.tableId, insertRequest).Do()
@@ -218,7 +198,7 @@ func (c *Client) InsertRow(rowData map[string]interface{}) error {
 	}
 
 	if len(result.InsertErrors) > 0 {
-		return fmt.Errorf("Insertion for %d rows failed")
+		return fmt.Errorf("Insertion for %d rows failed", len(result.InsertErrors))
 	}
 	return nil
 }
@@ -233,45 +213,26 @@ func (c *Client) GetTableName() (string, error) {
 
 // Do a synchronous query on bigtable and return a header and data rows.
 // Number of rows are capped to queryLimit.
+//
+// Query is a thin, back-compat wrapper around QueryIter, which (like
+// QueryAll) submits the query as a job and page through the full result set rather
+// than relying on the single-page legacy Jobs.Query call. Prefer QueryAll
+// or QueryIter directly for result sets that may exceed queryLimit rows.
 func (c *Client) Query(query string) ([]string, [][]interface{}, error) {
-	service, err := c.getService()
+	it, err := c.QueryIter(context.Background(), query, QueryOptions{PageSize: queryLimit})
 	if err != nil {
 		return nil, nil, err
 	}
-	datasetRef := &bigquery.DatasetReference{
-		DatasetId: c.datasetId,
-		ProjectId: *projectId,
-	}
 
-	queryRequest := &bigquery.QueryRequest{
-		DefaultDataset: datasetRef,
-		MaxResults:     queryLimit,
-		Kind:           "json",
-		Query:          query,
+	rows := [][]interface{}{}
+	for len(rows) < int(queryLimit) && it.Next() {
+		rows = append(rows, it.Row())
 	}
-
-	results, err := service.Jobs.Query(*projectId, queryRequest).Do()
-	if err != nil {
+	if err := it.Err(); err != nil {
 		return nil, nil, err
 	}
-	numRows := results.TotalRows
-	if numRows < 1 {
+	if len(rows) < 1 {
 		return nil, nil, fmt.Errorf("Query returned no data")
 	}
-
-	headers := []string{}
-	for _, col := range results.Schema.Fields {
-		headers = append(headers, col.Name)
-	}
-
-	rows := [][]interface{}{}
-	numColumns := len(results.Schema.Fields)
-	for _, data := range results.Rows {
-		row := make([]interface{}, numColumns)
-		for c := 0; c < numColumns; c++ {
-			row[c] = data.F[c].V
-		}
-		rows = append(rows, row)
-	}
-	return headers, rows, nil
+	return it.Header(), rows, nil
 }
\ No newline at end of file
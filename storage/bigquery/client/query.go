@@ -0,0 +1,280 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	bigquery "code.google.com/p/google-api-go-client/bigquery/v2"
+	"golang.org/x/net/context"
+)
+
+// defaultPageSize is used by QueryIter/QueryAll when QueryOptions.PageSize
+// is left at zero.
+const defaultPageSize int64 = 1000
+
+// jobPollInterval is how often a running query job is polled for
+// completion.
+const jobPollInterval = 500 * time.Millisecond
+
+// QueryOptions configures QueryIter and QueryAll.
+type QueryOptions struct {
+	// PageSize is the number of rows requested per Jobs.GetQueryResults
+	// call. Defaults to defaultPageSize.
+	PageSize int64
+}
+
+// RowIterator pages through the results of a query job submitted via
+// Jobs.Insert, decoding each column according to its declared schema type
+// instead of handing back raw strings.
+type RowIterator struct {
+	ctx     context.Context
+	client  *Client
+	jobRef  *bigquery.JobReference
+	opts    QueryOptions
+	header  []string
+	colType []string
+
+	rows      []*bigquery.TableRow
+	rowIdx    int
+	pageToken string
+	started   bool
+	done      bool
+	err       error
+	current   []interface{}
+}
+
+// QueryIter submits query as an asynchronous job and returns an iterator
+// over its results, fetched a page at a time via Jobs.GetQueryResults. It
+// replaces the 200-row cap of Query for callers that need the full result
+// set and don't want to hold it all in memory at once. ctx may be used to
+// cancel polling and paging.
+func (c *Client) QueryIter(ctx context.Context, query string, opts QueryOptions) (*RowIterator, error) {
+	service, err := c.getService()
+	if err != nil {
+		return nil, err
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = defaultPageSize
+	}
+
+	job := &bigquery.Job{
+		Configuration: &bigquery.JobConfiguration{
+			Query: &bigquery.JobConfigurationQuery{
+				Query: query,
+				DefaultDataset: &bigquery.DatasetReference{
+					DatasetId: c.datasetId,
+					ProjectId: *projectId,
+				},
+			},
+		},
+	}
+	inserted, err := service.Jobs.Insert(*projectId, job).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit query job: %v", err)
+	}
+
+	it := &RowIterator{
+		ctx:    ctx,
+		client: c,
+		jobRef: inserted.JobReference,
+		opts:   opts,
+	}
+	if err := it.waitForJob(); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// waitForJob polls Jobs.Get until the submitted job reaches state "DONE",
+// surfacing a job error if one occurred.
+func (it *RowIterator) waitForJob() error {
+	service, err := it.client.getService()
+	if err != nil {
+		return err
+	}
+	for {
+		if err := it.ctx.Err(); err != nil {
+			return err
+		}
+		job, err := service.Jobs.Get(*projectId, it.jobRef.JobId).Do()
+		if err != nil {
+			return fmt.Errorf("failed to poll query job: %v", err)
+		}
+		if job.Status.State == "DONE" {
+			if job.Status.ErrorResult != nil {
+				return fmt.Errorf("query job failed: %v", job.Status.ErrorResult.Message)
+			}
+			return nil
+		}
+		time.Sleep(jobPollInterval)
+	}
+}
+
+// fetchPage retrieves the next page of results, starting the result set on
+// the first call.
+func (it *RowIterator) fetchPage() error {
+	if err := it.ctx.Err(); err != nil {
+		return err
+	}
+	service, err := it.client.getService()
+	if err != nil {
+		return err
+	}
+
+	call := service.Jobs.GetQueryResults(*projectId, it.jobRef.JobId)
+	call.MaxResults(it.opts.PageSize)
+	if it.pageToken != "" {
+		call.PageToken(it.pageToken)
+	}
+
+	results, err := call.Do()
+	if err != nil {
+		return fmt.Errorf("failed to fetch query results: %v", err)
+	}
+
+	if !it.started {
+		it.started = true
+		for _, col := range results.Schema.Fields {
+			it.header = append(it.header, col.Name)
+			it.colType = append(it.colType, col.Type)
+		}
+	}
+
+	it.rows = results.Rows
+	it.rowIdx = 0
+	it.pageToken = results.PageToken
+	if it.pageToken == "" {
+		it.done = true
+	}
+	return nil
+}
+
+// Header returns the column names of the query result. It is only valid
+// after the first call to Next.
+func (it *RowIterator) Header() []string {
+	return it.header
+}
+
+// Next advances the iterator to the next row, fetching additional pages as
+// needed. It returns false when the result set is exhausted or an error
+// occurred; callers should check Err in that case.
+func (it *RowIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	for it.rowIdx >= len(it.rows) {
+		if it.started && it.done {
+			return false
+		}
+		if err := it.fetchPage(); err != nil {
+			it.err = err
+			return false
+		}
+		if len(it.rows) == 0 && it.done {
+			return false
+		}
+	}
+
+	row, err := decodeRow(it.rows[it.rowIdx], it.colType)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.current = row
+	it.rowIdx++
+	return true
+}
+
+// Row returns the values decoded for the row Next just advanced to.
+func (it *RowIterator) Row() []interface{} {
+	return it.current
+}
+
+// Err returns the first error encountered while paging, if any.
+func (it *RowIterator) Err() error {
+	return it.err
+}
+
+// decodeRow converts the raw string values of a TableRow into typed Go
+// values, using colType (parallel to the row's columns) to pick the
+// conversion.
+func decodeRow(row *bigquery.TableRow, colType []string) ([]interface{}, error) {
+	values := make([]interface{}, len(row.F))
+	for i, cell := range row.F {
+		t := ""
+		if i < len(colType) {
+			t = colType[i]
+		}
+		v, err := decodeValue(t, cell.V)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %v", i, err)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// decodeValue converts a single raw cell value, as returned by the
+// bigquery v2 API (always JSON strings), into a typed Go value according
+// to colType.
+func decodeValue(colType string, raw interface{}) (interface{}, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	s, ok := raw.(string)
+	if !ok {
+		return raw, nil
+	}
+
+	switch colType {
+	case "INTEGER":
+		return strconv.ParseInt(s, 10, 64)
+	case "FLOAT":
+		return strconv.ParseFloat(s, 64)
+	case "BOOLEAN":
+		return strconv.ParseBool(s)
+	case "TIMESTAMP":
+		seconds, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		nanos := int64((seconds - float64(int64(seconds))) * float64(time.Second))
+		return time.Unix(int64(seconds), nanos).UTC(), nil
+	default:
+		return s, nil
+	}
+}
+
+// QueryAll runs query to completion as a job and returns every row,
+// decoded per-column like QueryIter. Use this instead of Query when the
+// result set may exceed a couple hundred rows.
+func (c *Client) QueryAll(ctx context.Context, query string) ([]string, [][]interface{}, error) {
+	it, err := c.QueryIter(ctx, query, QueryOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows := [][]interface{}{}
+	for it.Next() {
+		rows = append(rows, it.Row())
+	}
+	if err := it.Err(); err != nil {
+		return nil, nil, err
+	}
+	return it.Header(), rows, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+	"time"
+
+	bigquery "code.google.com/p/google-api-go-client/bigquery/v2"
+)
+
+func TestDecodeValue(t *testing.T) {
+	cases := []struct {
+		colType string
+		raw     interface{}
+		want    interface{}
+	}{
+		{"INTEGER", "42", int64(42)},
+		{"FLOAT", "3.5", float64(3.5)},
+		{"BOOLEAN", "true", true},
+		{"STRING", "hello", "hello"},
+		{"", "hello", "hello"},
+	}
+
+	for _, c := range cases {
+		got, err := decodeValue(c.colType, c.raw)
+		if err != nil {
+			t.Errorf("decodeValue(%q, %v) returned error: %v", c.colType, c.raw, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("decodeValue(%q, %v) = %v (%T), want %v (%T)", c.colType, c.raw, got, got, c.want, c.want)
+		}
+	}
+}
+
+func TestDecodeValueTimestamp(t *testing.T) {
+	got, err := decodeValue("TIMESTAMP", "1437058000.5")
+	if err != nil {
+		t.Fatalf("decodeValue returned error: %v", err)
+	}
+	ts, ok := got.(time.Time)
+	if !ok {
+		t.Fatalf("expected a time.Time, got %T", got)
+	}
+	want := time.Unix(1437058000, 500000000).UTC()
+	if !ts.Equal(want) {
+		t.Errorf("decodeValue(TIMESTAMP) = %v, want %v", ts, want)
+	}
+}
+
+func TestDecodeValueNil(t *testing.T) {
+	got, err := decodeValue("INTEGER", nil)
+	if err != nil {
+		t.Fatalf("decodeValue returned error: %v", err)
+	}
+	if got != nil {
+		t.Errorf("decodeValue(nil) = %v, want nil", got)
+	}
+}
+
+func TestDecodeRow(t *testing.T) {
+	row := &bigquery.TableRow{
+		F: []*bigquery.TableCell{
+			{V: "7"},
+			{V: "false"},
+			{V: "leaf"},
+		},
+	}
+	colType := []string{"INTEGER", "BOOLEAN", "STRING"}
+
+	values, err := decodeRow(row, colType)
+	if err != nil {
+		t.Fatalf("decodeRow returned error: %v", err)
+	}
+	if len(values) != 3 {
+		t.Fatalf("expected 3 values, got %d", len(values))
+	}
+	if values[0] != int64(7) || values[1] != false || values[2] != "leaf" {
+		t.Fatalf("unexpected decoded row: %v", values)
+	}
+}
@@ -0,0 +1,319 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	bigquery "code.google.com/p/google-api-go-client/bigquery/v2"
+)
+
+// Field tags recognized when deriving a schema from a struct. The primary
+// way to annotate a field is the combined `bq` tag:
+//
+//	Count int64 `bq:"count,type=INTEGER,mode=NULLABLE"`
+//
+// The individual `column`, `bq_type` and `bq_mode` tags are also honored so
+// existing struct tags don't need to be rewritten:
+//
+//	Count int64 `column:"count" bq_type:"INTEGER" bq_mode:"NULLABLE"`
+//
+// A field tagged `json:"-"` is skipped entirely, matching encoding/json.
+const (
+	tagBq     = "bq"
+	tagColumn = "column"
+	tagType   = "bq_type"
+	tagMode   = "bq_mode"
+	tagJson   = "json"
+)
+
+// fieldTag is the parsed result of the tags on a single struct field.
+type fieldTag struct {
+	name     string
+	bqType   string
+	bqMode   string
+	skip     bool
+	omitzero bool
+}
+
+// parseFieldTag extracts the bigquery column name, type and mode from a
+// struct field, falling back to the field name when no tag is present. An
+// untagged type or mode is left empty here; fieldSchema fills it in.
+func parseFieldTag(f reflect.StructField) fieldTag {
+	tag := fieldTag{name: f.Name}
+
+	if json := f.Tag.Get(tagJson); json != "" {
+		parts := strings.Split(json, ",")
+		if parts[0] == "-" {
+			tag.skip = true
+			return tag
+		}
+		if parts[0] != "" {
+			tag.name = parts[0]
+		}
+		for _, opt := range parts[1:] {
+			if opt == "omitempty" {
+				tag.omitzero = true
+			}
+		}
+	}
+
+	if bq := f.Tag.Get(tagBq); bq != "" {
+		parts := strings.Split(bq, ",")
+		if parts[0] == "-" {
+			tag.skip = true
+			return tag
+		}
+		if parts[0] != "" {
+			tag.name = parts[0]
+		}
+		for _, opt := range parts[1:] {
+			if strings.HasPrefix(opt, "type=") {
+				tag.bqType = strings.TrimPrefix(opt, "type=")
+			} else if strings.HasPrefix(opt, "mode=") {
+				tag.bqMode = strings.TrimPrefix(opt, "mode=")
+			} else if opt == "omitempty" {
+				tag.omitzero = true
+			}
+		}
+	}
+
+	if column := f.Tag.Get(tagColumn); column != "" {
+		tag.name = column
+	}
+	if bqType := f.Tag.Get(tagType); bqType != "" {
+		tag.bqType = bqType
+	}
+	if bqMode := f.Tag.Get(tagMode); bqMode != "" {
+		tag.bqMode = bqMode
+	}
+
+	return tag
+}
+
+// bqTypeForKind guesses a TableFieldSchema type for a Go kind when no
+// explicit `type=` tag is given.
+func bqTypeForKind(t reflect.Type) (string, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "INTEGER", nil
+	case reflect.Float32, reflect.Float64:
+		return "FLOAT", nil
+	case reflect.String:
+		return "STRING", nil
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return "TIMESTAMP", nil
+		}
+		return "RECORD", nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "BYTES", nil
+		}
+		return bqTypeForKind(t.Elem())
+	case reflect.Ptr:
+		return bqTypeForKind(t.Elem())
+	}
+	return "", fmt.Errorf("cannot infer bigquery type for kind %v", t.Kind())
+}
+
+// SchemaFromStruct derives a bigquery TableSchema from the exported fields
+// of v, which must be a struct or a pointer to one. Struct tags control the
+// generated column name, type and mode; see the comment on the tag
+// constants above for the supported tag forms. Nested structs and slices of structs are
+// recursed into and emitted as RECORD/REPEATED fields.
+func SchemaFromStruct(v interface{}) (*bigquery.TableSchema, error) {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("SchemaFromStruct: expected a struct, got %v", t.Kind())
+	}
+
+	fields, err := fieldsFromStruct(t)
+	if err != nil {
+		return nil, err
+	}
+	return &bigquery.TableSchema{Fields: fields}, nil
+}
+
+func fieldsFromStruct(t reflect.Type) ([]*bigquery.TableFieldSchema, error) {
+	fields := []*bigquery.TableFieldSchema{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// Unexported field.
+			continue
+		}
+		tag := parseFieldTag(f)
+		if tag.skip {
+			continue
+		}
+
+		schema, err := fieldSchema(f.Type, tag)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %v", f.Name, err)
+		}
+		fields = append(fields, schema)
+	}
+	return fields, nil
+}
+
+func fieldSchema(t reflect.Type, tag fieldTag) (*bigquery.TableFieldSchema, error) {
+	repeated := false
+	elemType := t
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() == reflect.Slice && elemType.Elem().Kind() != reflect.Uint8 {
+		repeated = true
+		elemType = elemType.Elem()
+		for elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+		}
+	}
+
+	field := &bigquery.TableFieldSchema{Name: tag.name}
+
+	switch {
+	case tag.bqMode != "":
+		field.Mode = tag.bqMode
+	case repeated:
+		field.Mode = "REPEATED"
+	default:
+		field.Mode = "NULLABLE"
+	}
+
+	if tag.bqType != "" {
+		field.Type = tag.bqType
+	} else {
+		bqType, err := bqTypeForKind(elemType)
+		if err != nil {
+			return nil, err
+		}
+		field.Type = bqType
+	}
+
+	if field.Type == "RECORD" {
+		nested, err := fieldsFromStruct(elemType)
+		if err != nil {
+			return nil, err
+		}
+		field.Fields = nested
+	}
+
+	return field, nil
+}
+
+// InsertStruct converts v, a struct or pointer to one, into a row using the
+// same tag rules as SchemaFromStruct and inserts it into the connected
+// table. It is a convenience wrapper around InsertRow for callers that
+// declare their stat schema as a Go type rather than building a
+// map[string]interface{} by hand.
+func (c *Client) InsertStruct(v interface{}) error {
+	row, err := rowFromStruct(v)
+	if err != nil {
+		return err
+	}
+	return c.InsertRow(row)
+}
+
+func rowFromStruct(v interface{}) (map[string]interface{}, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("rowFromStruct: nil pointer")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("rowFromStruct: expected a struct, got %v", rv.Kind())
+	}
+
+	row := map[string]interface{}{}
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := parseFieldTag(f)
+		if tag.skip {
+			continue
+		}
+
+		fv := rv.Field(i)
+		if tag.omitzero && isZero(fv) {
+			continue
+		}
+
+		value, err := rowValue(fv)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %v", f.Name, err)
+		}
+		if value != nil {
+			row[tag.name] = value
+		}
+	}
+	return row, nil
+}
+
+func rowValue(v reflect.Value) (interface{}, error) {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Type() == reflect.TypeOf(time.Time{}):
+		return v.Interface().(time.Time).Format(time.RFC3339), nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		return v.Interface().([]byte), nil
+	case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+		values := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elem, err := rowValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, elem)
+		}
+		return values, nil
+	case v.Kind() == reflect.Struct:
+		row, err := rowFromStruct(v.Interface())
+		if err != nil {
+			return nil, err
+		}
+		return row, nil
+	default:
+		return v.Interface(), nil
+	}
+}
+
+// isZero reports whether v holds its type's zero value, used to implement
+// `omitempty` semantics.
+func isZero(v reflect.Value) bool {
+	zero := reflect.Zero(v.Type()).Interface()
+	return reflect.DeepEqual(v.Interface(), zero)
+}
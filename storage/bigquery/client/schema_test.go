@@ -0,0 +1,146 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+type nestedStat struct {
+	Name  string `bq:"name"`
+	Count int64  `bq:"count,type=INTEGER"`
+}
+
+type containerStat struct {
+	Timestamp time.Time    `bq:"timestamp,type=TIMESTAMP"`
+	Cpu       int64        `bq:"cpu_usage,type=INTEGER,mode=REQUIRED"`
+	Hidden    string       `json:"-"`
+	Label     string       `column:"label" bq_type:"STRING" bq_mode:"NULLABLE"`
+	Nested    nestedStat   `bq:"nested"`
+	Devices   []nestedStat `bq:"devices"`
+}
+
+func TestSchemaFromStructBasicTags(t *testing.T) {
+	schema, err := SchemaFromStruct(containerStat{})
+	if err != nil {
+		t.Fatalf("SchemaFromStruct returned error: %v", err)
+	}
+
+	byName := map[string]bool{}
+	for _, f := range schema.Fields {
+		byName[f.Name] = true
+	}
+	if byName["Hidden"] {
+		t.Fatalf("expected json:\"-\" field to be skipped, got fields %v", schema.Fields)
+	}
+
+	var timestamp, cpu, label, nested, devices bool
+	for _, f := range schema.Fields {
+		switch f.Name {
+		case "timestamp":
+			timestamp = true
+			if f.Type != "TIMESTAMP" {
+				t.Errorf("expected timestamp field type TIMESTAMP, got %s", f.Type)
+			}
+		case "cpu_usage":
+			cpu = true
+			if f.Type != "INTEGER" || f.Mode != "REQUIRED" {
+				t.Errorf("expected cpu_usage INTEGER/REQUIRED, got %s/%s", f.Type, f.Mode)
+			}
+		case "label":
+			label = true
+			if f.Type != "STRING" {
+				t.Errorf("expected label type STRING (from column/bq_type tags), got %s", f.Type)
+			}
+		case "nested":
+			nested = true
+			if f.Type != "RECORD" || len(f.Fields) != 2 {
+				t.Errorf("expected nested RECORD with 2 fields, got type %s with %d fields", f.Type, len(f.Fields))
+			}
+		case "devices":
+			devices = true
+			if f.Type != "RECORD" || f.Mode != "REPEATED" {
+				t.Errorf("expected devices RECORD/REPEATED, got %s/%s", f.Type, f.Mode)
+			}
+		}
+	}
+	if !timestamp || !cpu || !label || !nested || !devices {
+		t.Fatalf("missing expected fields in schema: %v", schema.Fields)
+	}
+}
+
+func TestRowFromStructSkipsAndRecurses(t *testing.T) {
+	now := time.Date(2026, 7, 29, 12, 0, 0, 0, time.UTC)
+	stat := containerStat{
+		Timestamp: now,
+		Cpu:       42,
+		Hidden:    "should not appear",
+		Label:     "prod",
+		Nested:    nestedStat{Name: "eth0", Count: 3},
+		Devices:   []nestedStat{{Name: "eth0", Count: 3}, {Name: "eth1", Count: 5}},
+	}
+
+	row, err := rowFromStruct(stat)
+	if err != nil {
+		t.Fatalf("rowFromStruct returned error: %v", err)
+	}
+
+	if _, ok := row["Hidden"]; ok {
+		t.Fatalf("expected json:\"-\" field to be excluded from row, got %v", row)
+	}
+	if row["timestamp"] != now.Format(time.RFC3339) {
+		t.Errorf("expected formatted timestamp, got %v", row["timestamp"])
+	}
+	if row["cpu_usage"] != int64(42) {
+		t.Errorf("expected cpu_usage 42, got %v", row["cpu_usage"])
+	}
+
+	nested, ok := row["nested"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected nested to decode to a map, got %T", row["nested"])
+	}
+	if nested["name"] != "eth0" || nested["count"] != int64(3) {
+		t.Errorf("unexpected nested row: %v", nested)
+	}
+
+	devices, ok := row["devices"].([]interface{})
+	if !ok || len(devices) != 2 {
+		t.Fatalf("expected devices to decode to a 2-element slice, got %v", row["devices"])
+	}
+}
+
+type omitStruct struct {
+	Name string `bq:"name,omitempty"`
+	Age  int64  `bq:"age,type=INTEGER,omitempty"`
+}
+
+func TestRowFromStructOmitsZeroFieldsWithOmitempty(t *testing.T) {
+	row, err := rowFromStruct(omitStruct{Name: "", Age: 0})
+	if err != nil {
+		t.Fatalf("rowFromStruct returned error: %v", err)
+	}
+	if len(row) != 0 {
+		t.Fatalf("expected all-zero omitempty fields to be omitted, got %v", row)
+	}
+
+	row, err = rowFromStruct(omitStruct{Name: "x", Age: 1})
+	if err != nil {
+		t.Fatalf("rowFromStruct returned error: %v", err)
+	}
+	if row["name"] != "x" || row["age"] != int64(1) {
+		t.Fatalf("expected non-zero fields to be present, got %v", row)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	bigquery "code.google.com/p/google-api-go-client/bigquery/v2"
+)
+
+// TimePartitioning describes day-partitioning for a newly created table. It
+// mirrors the subset of the real BigQuery API's TimePartitioning message
+// that CreateTableWithOptions would need; see the KNOWN GAP note on
+// CreateTableOptions for why setting it currently returns an error instead
+// of creating a partitioned table.
+type TimePartitioning struct {
+	// Type is the partitioning type, e.g. "DAY". It is currently the only
+	// value the real API supports.
+	Type string
+
+	// ExpirationMs, if non-zero, is how long BigQuery keeps data in a
+	// partition before deleting it.
+	ExpirationMs int64
+
+	// Field is the name of the column used to determine partition
+	// assignment. If empty, BigQuery partitions by load/arrival time.
+	Field string
+}
+
+// CreateTableOptions configures CreateTableWithOptions.
+//
+// KNOWN GAP: Partitioning and Clustering are accepted here because
+// day-partitioned tables are essential for querying time-series stats
+// efficiently, but they are not yet implemented. The vendored
+// code.google.com/p/google-api-go-client/bigquery/v2 predates both features
+// in the real BigQuery API and has no TimePartitioning or Clustering types
+// on bigquery.Table to populate, so CreateTableWithOptions rejects any
+// request that sets them with ErrPartitioningUnsupported rather than
+// silently creating an unpartitioned table. Follow-up: migrate this
+// package off that legacy client and wire these through to
+// bigquery.Table.TimePartitioning/Clustering.
+type CreateTableOptions struct {
+	// AllowAdd permits an existing table's schema to be extended with
+	// new NULLABLE/REPEATED fields via Tables.Patch. If false,
+	// CreateTableWithOptions never modifies an existing table's schema,
+	// but still reports incompatible changes via ErrIncompatibleSchema.
+	AllowAdd bool
+
+	// Partitioning requests a day-partitioned table. See the KNOWN GAP
+	// note above: non-nil values are currently rejected.
+	Partitioning *TimePartitioning
+
+	// Clustering requests clustering on the named columns. See the KNOWN
+	// GAP note above: non-empty values are currently rejected.
+	Clustering []string
+}
+
+// ErrPartitioningUnsupported is returned by CreateTableWithOptions when
+// opts.Partitioning or opts.Clustering is set. See the KNOWN GAP note on
+// CreateTableOptions.
+var ErrPartitioningUnsupported = fmt.Errorf("bigquery: CreateTableOptions.Partitioning/Clustering are not yet supported by the vendored bigquery/v2 client (tracked as a follow-up; see CreateTableOptions)")
+
+// ErrIncompatibleSchema is returned by CreateTableWithOptions when a table
+// already exists and its schema differs from the requested one in a way
+// BigQuery cannot reconcile in place: a field was removed, a new field is
+// REQUIRED, a field's type changed, or a field's mode was tightened (e.g.
+// NULLABLE to REQUIRED).
+// Callers should create a new, versioned table instead.
+type ErrIncompatibleSchema struct {
+	Fields []string
+}
+
+func (e *ErrIncompatibleSchema) Error() string {
+	return fmt.Sprintf("bigquery: incompatible schema change for field(s): %s", strings.Join(e.Fields, ", "))
+}
+
+// CreateTableWithOptions creates tableId with schema if it doesn't exist
+// yet. If the table already exists and schema is non-nil, its current
+// schema is fetched and diffed against schema: new fields are appended via
+// Tables.Patch when opts.AllowAdd is set, and any removed field, new
+// REQUIRED field, changed type, or tightened mode is reported as an
+// *ErrIncompatibleSchema naming
+// the offending fields. A nil schema against an existing table is treated
+// as "leave it as-is" rather than as a request to remove every field.
+//
+// opts.Partitioning and opts.Clustering are rejected with
+// ErrPartitioningUnsupported; see the KNOWN GAP note on CreateTableOptions.
+func (c *Client) CreateTableWithOptions(tableId string, schema *bigquery.TableSchema, opts CreateTableOptions) error {
+	if opts.Partitioning != nil || len(opts.Clustering) > 0 {
+		return ErrPartitioningUnsupported
+	}
+	if c.service == nil || c.datasetId == "" {
+		return fmt.Errorf("No dataset created")
+	}
+
+	existing, err := c.service.Tables.Get(*projectId, c.datasetId, tableId).Do()
+	if err != nil {
+		table := &bigquery.Table{
+			Schema: schema,
+			TableReference: &bigquery.TableReference{
+				DatasetId: c.datasetId,
+				ProjectId: *projectId,
+				TableId:   tableId,
+			},
+		}
+		if _, err := c.service.Tables.Insert(*projectId, c.datasetId, table).Do(); err != nil {
+			return err
+		}
+		c.tableId = tableId
+		return nil
+	}
+
+	if schema == nil {
+		c.tableId = tableId
+		return nil
+	}
+
+	added, incompatible := diffSchema(existing.Schema, schema)
+	if len(incompatible) > 0 {
+		return &ErrIncompatibleSchema{Fields: incompatible}
+	}
+
+	if len(added) > 0 && opts.AllowAdd {
+		patched := &bigquery.TableSchema{Fields: append(existing.Schema.Fields, added...)}
+		if _, err := c.service.Tables.Patch(*projectId, c.datasetId, tableId, &bigquery.Table{Schema: patched}).Do(); err != nil {
+			return err
+		}
+	}
+
+	c.tableId = tableId
+	return nil
+}
+
+// normalizeMode maps the empty mode string, which the BigQuery API treats
+// as NULLABLE (and which callers routinely leave unset when building a
+// TableFieldSchema by hand), to the literal "NULLABLE" so it compares
+// equal to an explicit one.
+func normalizeMode(mode string) string {
+	if mode == "" {
+		return "NULLABLE"
+	}
+	return mode
+}
+
+// diffSchema compares an existing table schema against a requested one.
+// added holds requested fields absent from existing, suitable for
+// appending via Tables.Patch. incompatible names fields that cannot be
+// reconciled: removed fields, new REQUIRED fields, type changes, or mode
+// changes other than relaxing REQUIRED to NULLABLE.
+func diffSchema(existing, requested *bigquery.TableSchema) (added []*bigquery.TableFieldSchema, incompatible []string) {
+	byName := make(map[string]*bigquery.TableFieldSchema, len(existing.Fields))
+	for _, f := range existing.Fields {
+		byName[f.Name] = f
+	}
+
+	seen := make(map[string]bool, len(requested.Fields))
+	for _, f := range requested.Fields {
+		seen[f.Name] = true
+		current, ok := byName[f.Name]
+		if !ok {
+			if normalizeMode(f.Mode) == "REQUIRED" {
+				incompatible = append(incompatible, f.Name)
+				continue
+			}
+			added = append(added, f)
+			continue
+		}
+		if current.Type != f.Type {
+			incompatible = append(incompatible, f.Name)
+			continue
+		}
+		currentMode, requestedMode := normalizeMode(current.Mode), normalizeMode(f.Mode)
+		if currentMode != requestedMode && !(currentMode == "REQUIRED" && requestedMode == "NULLABLE") {
+			incompatible = append(incompatible, f.Name)
+		}
+	}
+
+	for _, f := range existing.Fields {
+		if !seen[f.Name] {
+			incompatible = append(incompatible, f.Name)
+		}
+	}
+
+	return added, incompatible
+}
@@ -0,0 +1,121 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	bigquery "code.google.com/p/google-api-go-client/bigquery/v2"
+)
+
+func field(name, typ, mode string) *bigquery.TableFieldSchema {
+	return &bigquery.TableFieldSchema{Name: name, Type: typ, Mode: mode}
+}
+
+func TestDiffSchemaNewField(t *testing.T) {
+	existing := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "STRING", "NULLABLE")}}
+	requested := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{
+		field("a", "STRING", "NULLABLE"),
+		field("b", "INTEGER", "NULLABLE"),
+	}}
+
+	added, incompatible := diffSchema(existing, requested)
+	if len(incompatible) != 0 {
+		t.Fatalf("expected no incompatible fields, got %v", incompatible)
+	}
+	if len(added) != 1 || added[0].Name != "b" {
+		t.Fatalf("expected field b to be added, got %v", added)
+	}
+}
+
+func TestDiffSchemaEmptyModeMatchesNullable(t *testing.T) {
+	// A field built by hand (e.g. storage/bigquery/driver.go's
+	// conversion from storage.Field) routinely leaves Mode unset, and
+	// the API itself omits Mode for NULLABLE columns in responses. Both
+	// must be treated as equivalent, not as a mode change.
+	existing := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "STRING", "")}}
+	requested := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "STRING", "NULLABLE")}}
+
+	added, incompatible := diffSchema(existing, requested)
+	if len(added) != 0 || len(incompatible) != 0 {
+		t.Fatalf("expected no changes for equivalent empty/NULLABLE modes, got added=%v incompatible=%v", added, incompatible)
+	}
+
+	// And the reverse direction.
+	existing = &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "STRING", "NULLABLE")}}
+	requested = &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "STRING", "")}}
+
+	added, incompatible = diffSchema(existing, requested)
+	if len(added) != 0 || len(incompatible) != 0 {
+		t.Fatalf("expected no changes for equivalent NULLABLE/empty modes, got added=%v incompatible=%v", added, incompatible)
+	}
+}
+
+func TestDiffSchemaRelaxedModeIsCompatible(t *testing.T) {
+	existing := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "STRING", "REQUIRED")}}
+	requested := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "STRING", "NULLABLE")}}
+
+	added, incompatible := diffSchema(existing, requested)
+	if len(added) != 0 || len(incompatible) != 0 {
+		t.Fatalf("expected relaxing REQUIRED to NULLABLE to be compatible, got added=%v incompatible=%v", added, incompatible)
+	}
+}
+
+func TestDiffSchemaTightenedModeIsIncompatible(t *testing.T) {
+	existing := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "STRING", "NULLABLE")}}
+	requested := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "STRING", "REQUIRED")}}
+
+	_, incompatible := diffSchema(existing, requested)
+	if len(incompatible) != 1 || incompatible[0] != "a" {
+		t.Fatalf("expected field a to be incompatible, got %v", incompatible)
+	}
+}
+
+func TestDiffSchemaChangedTypeIsIncompatible(t *testing.T) {
+	existing := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "STRING", "NULLABLE")}}
+	requested := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "INTEGER", "NULLABLE")}}
+
+	_, incompatible := diffSchema(existing, requested)
+	if len(incompatible) != 1 || incompatible[0] != "a" {
+		t.Fatalf("expected field a to be incompatible, got %v", incompatible)
+	}
+}
+
+func TestCreateTableWithOptionsRejectsPartitioning(t *testing.T) {
+	c := &Client{}
+
+	err := c.CreateTableWithOptions("t", nil, CreateTableOptions{Partitioning: &TimePartitioning{Type: "DAY"}})
+	if err != ErrPartitioningUnsupported {
+		t.Fatalf("expected ErrPartitioningUnsupported for Partitioning, got %v", err)
+	}
+
+	err = c.CreateTableWithOptions("t", nil, CreateTableOptions{Clustering: []string{"a"}})
+	if err != ErrPartitioningUnsupported {
+		t.Fatalf("expected ErrPartitioningUnsupported for Clustering, got %v", err)
+	}
+}
+
+func TestDiffSchemaRemovedFieldIsIncompatible(t *testing.T) {
+	existing := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{
+		field("a", "STRING", "NULLABLE"),
+		field("b", "STRING", "NULLABLE"),
+	}}
+	requested := &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{field("a", "STRING", "NULLABLE")}}
+
+	_, incompatible := diffSchema(existing, requested)
+	if len(incompatible) != 1 || incompatible[0] != "b" {
+		t.Fatalf("expected field b to be incompatible, got %v", incompatible)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bigquery adapts storage/bigquery/client to the storage.Driver
+// interface and registers itself under the "bigquery" scheme.
+package bigquery
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+
+	bq "code.google.com/p/google-api-go-client/bigquery/v2"
+
+	"github.com/danielkrainas/cadvisor/storage"
+	"github.com/danielkrainas/cadvisor/storage/bigquery/client"
+)
+
+func init() {
+	storage.Register("bigquery", newDriver)
+}
+
+// driver adapts a *client.Client to storage.Driver.
+type driver struct {
+	client       *client.Client
+	defaultTable string
+}
+
+// newDriver builds a bigquery storage.Driver from a URL of the form
+// "bigquery://project/dataset/table". Authentication is still controlled
+// by the client package's own flags
+// (-bq_credentials_json/-bq_credentials_file/-bq_account); only the
+// project, dataset and table come from the URL.
+func newDriver(u *url.URL) (storage.Driver, error) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("bigquery: URL must be of the form bigquery://project/dataset/table, got %q", u.String())
+	}
+	dataset, table := parts[0], parts[1]
+
+	if err := flag.Set("bq_project_id", u.Host); err != nil {
+		return nil, fmt.Errorf("bigquery: failed to set project id: %v", err)
+	}
+
+	c, err := client.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.CreateDataset(dataset); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return &driver{client: c, defaultTable: table}, nil
+}
+
+// CreateTable creates name (or, if name is empty, the table from the
+// driver's URL) with the given schema.
+func (d *driver) CreateTable(name string, schema *storage.Schema) error {
+	if name == "" {
+		name = d.defaultTable
+	}
+	return d.client.CreateTable(name, toBigquerySchema(schema))
+}
+
+func (d *driver) InsertRow(row map[string]interface{}) error {
+	return d.client.InsertRow(row)
+}
+
+func (d *driver) Query(q string) ([]string, [][]interface{}, error) {
+	return d.client.Query(q)
+}
+
+func (d *driver) Close() error {
+	return d.client.Close()
+}
+
+func toBigquerySchema(schema *storage.Schema) *bq.TableSchema {
+	if schema == nil {
+		return nil
+	}
+	return &bq.TableSchema{Fields: toBigqueryFields(schema.Fields)}
+}
+
+func toBigqueryFields(fields []storage.Field) []*bq.TableFieldSchema {
+	out := make([]*bq.TableFieldSchema, len(fields))
+	for i, f := range fields {
+		tf := &bq.TableFieldSchema{Name: f.Name, Type: f.Type, Mode: f.Mode}
+		if len(f.Fields) > 0 {
+			tf.Fields = toBigqueryFields(f.Fields)
+		}
+		out[i] = tf
+	}
+	return out
+}
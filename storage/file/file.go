@@ -0,0 +1,82 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file implements a minimal storage.Driver that appends rows as
+// newline-delimited JSON to a local file. It exists mainly as a second,
+// trivial driver alongside bigquery so the storage registry indirection is
+// actually exercised by more than one backend.
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/danielkrainas/cadvisor/storage"
+)
+
+func init() {
+	storage.Register("file", newDriver)
+}
+
+type driver struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// newDriver opens (creating if necessary) the file named by u.Path, e.g.
+// "file:///var/log/cadvisor.stats".
+func newDriver(u *url.URL) (storage.Driver, error) {
+	if u.Path == "" {
+		return nil, fmt.Errorf("file: URL must be of the form file:///path/to/file, got %q", u.String())
+	}
+	f, err := os.OpenFile(u.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("file: failed to open %q: %v", u.Path, err)
+	}
+	return &driver{file: f}, nil
+}
+
+// CreateTable is a no-op: a flat file has no schema to declare.
+func (d *driver) CreateTable(name string, schema *storage.Schema) error {
+	return nil
+}
+
+// InsertRow appends row to the file as a single line of JSON.
+func (d *driver) InsertRow(row map[string]interface{}) error {
+	line, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, err = d.file.Write(line)
+	return err
+}
+
+// Query is not supported by the file driver; rows must be read back out of
+// band.
+func (d *driver) Query(q string) ([]string, [][]interface{}, error) {
+	return nil, nil, fmt.Errorf("file: querying is not supported")
+}
+
+func (d *driver) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.file.Close()
+}
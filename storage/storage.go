@@ -0,0 +1,109 @@
+// Copyright 2014 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storage defines a pluggable backend abstraction for cadvisor's
+// historical stat storage, along with a registry of named drivers keyed by
+// URL scheme (e.g. "bigquery://project/dataset/table",
+// "file:///var/log/cadvisor.stats"). Backends register themselves with
+// Register from an init() function, mirroring how database/sql drivers
+// register themselves; cadvisor then picks one with New(url) instead of
+// hard-coding lifecycle plumbing around a single backend's client type.
+package storage
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// Field describes a single column of a Schema. Mode follows BigQuery's
+// convention ("NULLABLE", "REQUIRED", "REPEATED") since it is general
+// enough to express optional/required/array columns for other backends
+// too. Fields is only set when Type is "RECORD".
+type Field struct {
+	Name   string
+	Type   string
+	Mode   string
+	Fields []Field
+}
+
+// Schema describes the columns of a table passed to Driver.CreateTable.
+type Schema struct {
+	Fields []Field
+}
+
+// Driver is implemented by a storage backend, e.g. BigQuery or a local
+// file. cadvisor's storage layer talks to whichever Driver New returns
+// rather than a concrete backend type.
+type Driver interface {
+	// CreateTable creates, or validates the existence of, the named
+	// table with the given schema. Drivers with no notion of schema
+	// (e.g. a flat file) may treat this as a no-op.
+	CreateTable(name string, schema *Schema) error
+
+	// InsertRow writes a single row to the table selected by
+	// CreateTable.
+	InsertRow(row map[string]interface{}) error
+
+	// Query runs q and returns a header and the matching rows. Drivers
+	// that don't support querying should return an error.
+	Query(q string) ([]string, [][]interface{}, error)
+
+	// Close releases any resources held by the driver.
+	Close() error
+}
+
+// Factory constructs a Driver from a parsed storage URL, e.g.
+// "bigquery://project/dataset/table" or "file:///var/log/cadvisor.stats".
+type Factory func(u *url.URL) (Driver, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Factory)
+)
+
+// Register makes a driver factory available under name, which New matches
+// against a storage URL's scheme. It panics if Register is called twice
+// for the same name, or with a nil factory.
+func Register(name string, factory Factory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if factory == nil {
+		panic("storage: Register factory is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("storage: Register called twice for driver " + name)
+	}
+	drivers[name] = factory
+}
+
+// New parses rawURL and constructs the Driver registered for its scheme,
+// e.g. New("bigquery://my-project/stats/container") or
+// New("file:///var/log/cadvisor.stats"). The driver package implementing
+// that scheme must have been imported (for its init side effect) before
+// New is called.
+func New(rawURL string) (Driver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("storage: invalid URL %q: %v", rawURL, err)
+	}
+
+	driversMu.RLock()
+	factory, ok := drivers[u.Scheme]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("storage: unknown driver %q (forgotten import?)", u.Scheme)
+	}
+	return factory(u)
+}